@@ -0,0 +1,914 @@
+// Package consensus implements a small Raft subsystem used to replicate
+// writes across the cluster with linearizable semantics, replacing the
+// old min-ID-primary-plus-best-effort-fan-out scheme.
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	electionTimeoutMin = 150 * time.Millisecond
+	electionTimeoutMax = 300 * time.Millisecond
+	heartbeatInterval  = 50 * time.Millisecond
+	rpcTimeout         = 100 * time.Millisecond
+
+	// defaultSnapshotThreshold is the log length at which a leader will
+	// compact its log into a snapshot.
+	defaultSnapshotThreshold = 1000
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Command is a single write operation replicated through the log.
+type Command struct {
+	Op    string `json:"op"` // "set" or "delete"
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+
+	// HasExpectedResourceVersion and ExpectedResourceVersion carry a
+	// conditional write's If-Match check into the log, so every replica
+	// (leader included) performs the compare-and-write as a single
+	// operation on its single-threaded apply path instead of racing a
+	// separate pre-propose check against a concurrent proposal.
+	HasExpectedResourceVersion bool   `json:"hasExpectedResourceVersion,omitempty"`
+	ExpectedResourceVersion    uint64 `json:"expectedResourceVersion,omitempty"`
+}
+
+// Applier applies committed commands to the underlying store. The KVStore
+// implements this so committed log entries can be applied without the
+// consensus package knowing anything about storage. Apply assigns and
+// returns whatever store-specific version the write lands at (e.g. the
+// KVStore's resource version) and runs only on the single-threaded apply
+// path, in commit order, so that version is assigned deterministically the
+// same way on every replica rather than racing a separate counter bump
+// against Propose's log append. It also returns an error (e.g. a
+// conditional-write conflict); Apply must not block or depend on any
+// other node's state, since it runs identically on every replica.
+type Applier interface {
+	Apply(cmd Command) (version uint64, err error)
+}
+
+// Result is what Apply (or ApplyBatch) assigns a single command: the
+// version it landed at (e.g. a KVStore resource version) and any error,
+// such as a conditional-write conflict.
+type Result struct {
+	Version uint64
+	Err     error
+}
+
+// BatchApplier lets an Applier durably apply every entry committed in the
+// same advanceCommitLocked pass as a single unit (e.g. one fsynced Bolt
+// transaction) instead of one per entry. Node uses it automatically when
+// the Applier implements it; one that only implements Apply still works,
+// one entry at a time.
+type BatchApplier interface {
+	ApplyBatch(cmds []Command) []Result
+}
+
+// LogEntry is a single entry in a node's replicated log.
+type LogEntry struct {
+	Term    int     `json:"term"`
+	Index   int     `json:"index"`
+	Command Command `json:"command"`
+}
+
+// SnapshotEntry pairs a value with the resource version it was stored at.
+// Snapshots carry this instead of a bare string so a node caught up via
+// InstallSnapshot converges on the same resource versions as a node that
+// replayed the log entry by entry, keeping If-Match/CAS and watch
+// resourceVersion correct on every replica.
+type SnapshotEntry struct {
+	Value           string `json:"value"`
+	ResourceVersion uint64 `json:"resourceVersion"`
+}
+
+// Snapshot is a point-in-time copy of applied state, used both to compact
+// the log and to catch up a node that has fallen far behind.
+type Snapshot struct {
+	LastIncludedIndex int                      `json:"lastIncludedIndex"`
+	LastIncludedTerm  int                      `json:"lastIncludedTerm"`
+	State             map[string]SnapshotEntry `json:"state"`
+}
+
+// SnapshotSource lets the applier hand back a full copy of its state so it
+// can be embedded in a Snapshot.
+type SnapshotSource interface {
+	Snapshot() map[string]SnapshotEntry
+	Restore(state map[string]SnapshotEntry)
+}
+
+// Node is a single member of a Raft cluster.
+type Node struct {
+	mu sync.Mutex
+
+	id    int
+	peers map[int]string // peer id -> address, excludes self
+
+	applier           Applier
+	snapshotThreshold int
+
+	role        role
+	currentTerm int
+	votedFor    int
+	leaderID    int
+
+	log               []LogEntry // log[0] is always the entry just after the last snapshot
+	lastIncludedIndex int
+	lastIncludedTerm  int
+	commitIndex       int
+	lastApplied       int
+
+	nextIndex  map[int]int
+	matchIndex map[int]int
+
+	resetElectionAt time.Time
+	applyWaiters    map[int][]applyWaiter
+
+	storage StateStorage
+	client  *http.Client
+	metrics MetricsRecorder
+}
+
+// MetricsRecorder lets a caller observe replication RPC activity without
+// this package depending on any particular metrics library.
+type MetricsRecorder interface {
+	// ObserveReplication is called after every AppendEntries RPC this node
+	// sends as leader, reporting how long it took and whether it failed.
+	ObserveReplication(peerAddr string, d time.Duration, err error)
+}
+
+// Stats is a point-in-time snapshot of this node's Raft state, for callers
+// that want to expose it (e.g. as metrics gauges) without reaching into
+// Node's internals.
+type Stats struct {
+	IsLeader    bool
+	LeaderID    int
+	Term        int
+	CommitIndex int
+	LastApplied int
+	// MatchIndex is the highest log index known to be replicated to each
+	// peer, valid only while IsLeader is true.
+	MatchIndex map[int]int
+}
+
+// Stats returns a snapshot of this node's current Raft state.
+func (n *Node) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	matchIndex := make(map[int]int, len(n.matchIndex))
+	for id, idx := range n.matchIndex {
+		matchIndex[id] = idx
+	}
+	return Stats{
+		IsLeader:    n.role == leader,
+		LeaderID:    n.leaderID,
+		Term:        n.currentTerm,
+		CommitIndex: n.commitIndex,
+		LastApplied: n.lastApplied,
+		MatchIndex:  matchIndex,
+	}
+}
+
+// SetMetrics installs a MetricsRecorder to observe replication RPCs. It is
+// safe to call at any time, e.g. right after NewNode.
+func (n *Node) SetMetrics(m MetricsRecorder) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.metrics = m
+}
+
+// SetStorage installs a StateStorage so currentTerm, votedFor and the log
+// survive a restart, and immediately recovers any state it already holds.
+// Call it right after NewNode, before Run, so the recovered term/votedFor
+// are in place before this node can respond to any RPC.
+func (n *Node) SetStorage(storage StateStorage) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	state, found, err := storage.Load()
+	if err != nil {
+		return err
+	}
+	n.storage = storage
+	if found {
+		n.currentTerm = state.CurrentTerm
+		n.votedFor = state.VotedFor
+		n.log = state.Log
+		n.lastIncludedIndex = state.LastIncludedIndex
+		n.lastIncludedTerm = state.LastIncludedTerm
+		// The applier's own state only reflects entries up to whatever
+		// snapshot or log this node had compacted into before the
+		// restart (no Restore() call happens here, unlike
+		// HandleInstallSnapshot), so lastApplied/commitIndex must start
+		// there too - otherwise applyCommittedLocked would replay from
+		// index 1 into a log that no longer holds it, panicking on a
+		// negative indexOffsetLocked and then silently skipping every
+		// entry up to lastIncludedIndex once it limps past them.
+		n.lastApplied = n.lastIncludedIndex
+		if n.commitIndex < n.lastIncludedIndex {
+			n.commitIndex = n.lastIncludedIndex
+		}
+	}
+	return nil
+}
+
+// persistLocked durably saves currentTerm, votedFor and the log before this
+// node acts on them (granting a vote, or acknowledging a log mutation), so
+// a restart can never repeat a vote already granted in a term or forget
+// entries already acknowledged to a leader. It is a no-op if no
+// StateStorage was configured via SetStorage. Like fatalOnDiskError in the
+// KVStore backends, a write that reports success but didn't actually
+// persist is worse than stopping outright, so a failure here is fatal.
+// Callers must hold n.mu.
+func (n *Node) persistLocked() {
+	if n.storage == nil {
+		return
+	}
+	err := n.storage.Save(PersistentState{
+		CurrentTerm:       n.currentTerm,
+		VotedFor:          n.votedFor,
+		Log:               n.log,
+		LastIncludedIndex: n.lastIncludedIndex,
+		LastIncludedTerm:  n.lastIncludedTerm,
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to persist raft state")
+	}
+}
+
+// NewNode constructs a Raft node that will replicate into applier.
+func NewNode(id int, applier Applier) *Node {
+	return &Node{
+		id:                id,
+		peers:             make(map[int]string),
+		applier:           applier,
+		snapshotThreshold: defaultSnapshotThreshold,
+		role:              follower,
+		leaderID:          -1,
+		votedFor:          -1,
+		applyWaiters:      make(map[int][]applyWaiter),
+		client:            &http.Client{Timeout: rpcTimeout},
+	}
+}
+
+// SetPeers updates the set of other nodes this node replicates to. It is
+// safe to call at any time, e.g. in response to a /view update.
+func (n *Node) SetPeers(peers map[int]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers = make(map[int]string, len(peers))
+	for id, addr := range peers {
+		if id == n.id {
+			continue
+		}
+		n.peers[id] = addr
+	}
+}
+
+// Run starts the election timer and heartbeat loops in their own
+// goroutines and returns at once; the loops stop when ctx is cancelled.
+func (n *Node) Run(ctx context.Context) {
+	n.mu.Lock()
+	n.resetElectionAt = time.Now()
+	n.mu.Unlock()
+
+	go n.electionLoop(ctx)
+	go n.heartbeatLoop(ctx)
+}
+
+func randomElectionTimeout() time.Duration {
+	span := electionTimeoutMax - electionTimeoutMin
+	return electionTimeoutMin + time.Duration(rand.Int63n(int64(span)))
+}
+
+func (n *Node) electionLoop(ctx context.Context) {
+	timer := time.NewTimer(randomElectionTimeout())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			n.mu.Lock()
+			expired := time.Since(n.resetElectionAt) >= randomElectionTimeout()/2 // avoid tight-looping right after a reset
+			isLeader := n.role == leader
+			n.mu.Unlock()
+			if !isLeader && expired {
+				n.startElection(ctx)
+			}
+			timer.Reset(randomElectionTimeout())
+		}
+	}
+}
+
+func (n *Node) resetElectionTimer() {
+	n.resetElectionAt = time.Now()
+}
+
+func (n *Node) startElection(ctx context.Context) {
+	n.mu.Lock()
+	n.role = candidate
+	n.currentTerm++
+	term := n.currentTerm
+	n.votedFor = n.id
+	n.persistLocked()
+	n.resetElectionTimer()
+	lastIndex, lastTerm := n.lastLogIndexAndTermLocked()
+	peers := make(map[int]string, len(n.peers))
+	for id, addr := range n.peers {
+		peers[id] = addr
+	}
+	n.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{"node": n.id, "term": term}).Info("starting election")
+
+	votes := 1 // vote for self
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for peerID, addr := range peers {
+		wg.Add(1)
+		go func(peerID int, addr string) {
+			defer wg.Done()
+			req := RequestVoteArgs{
+				Term:         term,
+				CandidateID:  n.id,
+				LastLogIndex: lastIndex,
+				LastLogTerm:  lastTerm,
+			}
+			var resp RequestVoteReply
+			rctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+			defer cancel()
+			if err := n.call(rctx, addr, "/internal/raft/requestvote", req, &resp); err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if resp.Term > term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if resp.VoteGranted {
+				votes++
+			}
+		}(peerID, addr)
+	}
+	wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != candidate || n.currentTerm != term {
+		return // a higher term arrived, or we already became leader/follower
+	}
+	if votes*2 > len(peers)+1 {
+		n.becomeLeaderLocked()
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.role = leader
+	n.leaderID = n.id
+	n.nextIndex = make(map[int]int, len(n.peers))
+	n.matchIndex = make(map[int]int, len(n.peers))
+	nextIdx := n.lastIndexLocked() + 1
+	for id := range n.peers {
+		n.nextIndex[id] = nextIdx
+		n.matchIndex[id] = 0
+	}
+	logrus.WithFields(logrus.Fields{"node": n.id, "term": n.currentTerm}).Info("became leader")
+}
+
+// stepDown reverts to follower for a higher observed term. Caller must not
+// hold n.mu; this helper takes it itself.
+func (n *Node) stepDown(term int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stepDownLocked(term)
+}
+
+func (n *Node) stepDownLocked(term int) {
+	if term <= n.currentTerm && n.role != leader {
+		return
+	}
+	n.currentTerm = term
+	n.role = follower
+	n.votedFor = -1
+	n.resetElectionTimer()
+	n.persistLocked()
+}
+
+func (n *Node) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			isLeader := n.role == leader
+			peers := make(map[int]string, len(n.peers))
+			for id, addr := range n.peers {
+				peers[id] = addr
+			}
+			n.mu.Unlock()
+			if !isLeader {
+				continue
+			}
+			for peerID, addr := range peers {
+				go n.replicateTo(ctx, peerID, addr)
+			}
+		}
+	}
+}
+
+// IsLeader reports whether this node currently believes it is leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// LeaderHint returns the address of the node we last heard was leader, if
+// any, so followers can redirect clients instead of proxying.
+func (n *Node) LeaderHint() (addr string, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.leaderID == -1 {
+		return "", false
+	}
+	if n.leaderID == n.id {
+		return "", false // we are the leader, nothing to redirect to
+	}
+	addr, ok = n.peers[n.leaderID]
+	return addr, ok
+}
+
+// ErrNotLeader is returned by Propose when this node is not the leader.
+var ErrNotLeader = fmt.Errorf("not leader")
+
+// ErrEntryLost is returned by Propose when this node lost leadership before
+// its entry committed and a different leader's entry committed at the same
+// log index instead, so the original command was never actually applied.
+var ErrEntryLost = fmt.Errorf("proposed entry was overwritten by a different leader's entry at the same index")
+
+// applyResult is what an applyWaiter is resolved with: the version the
+// applier assigned the command (e.g. a KVStore resource version) and
+// whatever error Apply returned.
+type applyResult struct {
+	version uint64
+	err     error
+}
+
+// applyWaiter is a Propose call blocked on its entry being applied. term
+// identifies the entry it proposed, so applyCommittedLocked can tell a
+// waiter apart from a later command that committed at the same index
+// after a leadership change, rather than signalling success for whichever
+// command happened to land there.
+type applyWaiter struct {
+	term int
+	ch   chan applyResult
+}
+
+// Propose appends cmd to the leader's log and blocks until it has been
+// replicated to a quorum and applied, or ctx is cancelled. It returns the
+// version Apply assigned the command.
+func (n *Node) Propose(ctx context.Context, cmd Command) (uint64, error) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return 0, ErrNotLeader
+	}
+	entry := LogEntry{Term: n.currentTerm, Index: n.lastIndexLocked() + 1, Command: cmd}
+	n.log = append(n.log, entry)
+	n.persistLocked()
+	wait := make(chan applyResult, 1)
+	n.applyWaiters[entry.Index] = append(n.applyWaiters[entry.Index], applyWaiter{term: entry.Term, ch: wait})
+	peers := make(map[int]string, len(n.peers))
+	for id, addr := range n.peers {
+		peers[id] = addr
+	}
+	n.mu.Unlock()
+
+	for peerID, addr := range peers {
+		go n.replicateTo(ctx, peerID, addr)
+	}
+	n.maybeAdvanceCommit()
+
+	select {
+	case res := <-wait:
+		return res.version, res.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (n *Node) replicateTo(ctx context.Context, peerID int, addr string) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return
+	}
+	next := n.nextIndex[peerID]
+	if next <= n.lastIncludedIndex {
+		// The applier's state already includes every entry up to
+		// lastApplied, so that is the index this snapshot covers.
+		snap := Snapshot{LastIncludedIndex: n.lastApplied, LastIncludedTerm: n.termAtLocked(n.lastApplied)}
+		if src, ok := n.applier.(SnapshotSource); ok {
+			snap.State = src.Snapshot()
+		}
+		term := n.currentTerm
+		n.mu.Unlock()
+		n.sendSnapshot(ctx, peerID, addr, term, snap)
+		return
+	}
+	prevIndex := next - 1
+	prevTerm := n.termAtLocked(prevIndex)
+	entries := n.entriesFromLocked(next)
+	req := AppendEntriesArgs{
+		Term:         n.currentTerm,
+		LeaderID:     n.id,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	term := n.currentTerm
+	metrics := n.metrics
+	n.mu.Unlock()
+
+	var resp AppendEntriesReply
+	rctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	start := time.Now()
+	err := n.call(rctx, addr, "/internal/raft/appendentries", req, &resp)
+	if metrics != nil {
+		metrics.ObserveReplication(addr, time.Since(start), err)
+	}
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > term {
+		n.stepDownLocked(resp.Term)
+		return
+	}
+	if n.role != leader || n.currentTerm != term {
+		return
+	}
+	if resp.Success {
+		n.matchIndex[peerID] = prevIndex + len(entries)
+		n.nextIndex[peerID] = n.matchIndex[peerID] + 1
+	} else if n.nextIndex[peerID] > 1 {
+		// Once this drops to lastIncludedIndex, the next round sends
+		// InstallSnapshot instead of entries the log no longer holds.
+		n.nextIndex[peerID]--
+	}
+	n.advanceCommitLocked()
+}
+
+func (n *Node) maybeAdvanceCommit() {
+	n.mu.Lock()
+	n.advanceCommitLocked()
+	n.mu.Unlock()
+}
+
+// advanceCommitLocked finds the highest index replicated to a majority in
+// the current term and commits up to it.
+func (n *Node) advanceCommitLocked() {
+	if n.role != leader {
+		return
+	}
+	for idx := n.lastIndexLocked(); idx > n.commitIndex; idx-- {
+		if n.termAtLocked(idx) != n.currentTerm {
+			continue
+		}
+		count := 1 // self
+		for peerID := range n.peers {
+			if n.matchIndex[peerID] >= idx {
+				count++
+			}
+		}
+		if count*2 > len(n.peers)+1 {
+			n.commitIndex = idx
+			n.applyCommittedLocked()
+			break
+		}
+	}
+	if len(n.log) > n.snapshotThreshold {
+		n.compactLocked()
+	}
+}
+
+// applyCommittedLocked applies every committed-but-not-yet-applied entry,
+// in order, and resolves any Propose callers waiting on them. When the
+// applier implements BatchApplier, every entry committed in this pass is
+// applied as a single batch (e.g. one fsynced transaction) rather than one
+// per entry.
+func (n *Node) applyCommittedLocked() {
+	if n.lastApplied >= n.commitIndex {
+		return
+	}
+	entries := make([]LogEntry, 0, n.commitIndex-n.lastApplied)
+	for idx := n.lastApplied + 1; idx <= n.commitIndex; idx++ {
+		entries = append(entries, n.entryAtLocked(idx))
+	}
+
+	var results []Result
+	if batch, ok := n.applier.(BatchApplier); ok {
+		cmds := make([]Command, len(entries))
+		for i, e := range entries {
+			cmds[i] = e.Command
+		}
+		results = batch.ApplyBatch(cmds)
+	} else {
+		results = make([]Result, len(entries))
+		for i, e := range entries {
+			version, err := n.applier.Apply(e.Command)
+			results[i] = Result{Version: version, Err: err}
+		}
+	}
+
+	for i, entry := range entries {
+		n.lastApplied = entry.Index
+		for _, w := range n.applyWaiters[entry.Index] {
+			if w.term == entry.Term {
+				w.ch <- applyResult{version: results[i].Version, err: results[i].Err}
+			} else {
+				w.ch <- applyResult{err: ErrEntryLost}
+			}
+		}
+		delete(n.applyWaiters, entry.Index)
+	}
+}
+
+// compactLocked discards log entries up to commitIndex once the log has
+// grown past snapshotThreshold, so a node that restarts (or a follower
+// that reconnects far behind) can catch up via InstallSnapshot instead of
+// replaying the whole history.
+func (n *Node) compactLocked() {
+	if n.commitIndex <= n.lastIncludedIndex {
+		return
+	}
+	entry := n.entryAtLocked(n.commitIndex)
+	n.lastIncludedIndex = entry.Index
+	n.lastIncludedTerm = entry.Term
+	n.log = append([]LogEntry{}, n.log[n.indexOffsetLocked(entry.Index)+1:]...)
+}
+
+func (n *Node) sendSnapshot(ctx context.Context, peerID int, addr string, term int, snap Snapshot) {
+	req := InstallSnapshotArgs{Term: term, LeaderID: n.id, Snapshot: snap}
+	var resp InstallSnapshotReply
+	rctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	if err := n.call(rctx, addr, "/internal/raft/installsnapshot", req, &resp); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > term {
+		n.stepDownLocked(resp.Term)
+		return
+	}
+	if n.role == leader && n.currentTerm == term {
+		n.nextIndex[peerID] = snap.LastIncludedIndex + 1
+		n.matchIndex[peerID] = snap.LastIncludedIndex
+	}
+}
+
+// --- log index helpers; n.log is stored relative to lastIncludedIndex ---
+
+func (n *Node) lastIndexLocked() int {
+	if len(n.log) == 0 {
+		return n.lastIncludedIndex
+	}
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastLogIndexAndTermLocked() (int, int) {
+	idx := n.lastIndexLocked()
+	return idx, n.termAtLocked(idx)
+}
+
+func (n *Node) termAtLocked(index int) int {
+	if index == n.lastIncludedIndex {
+		return n.lastIncludedTerm
+	}
+	if index < n.lastIncludedIndex || index == 0 {
+		return 0
+	}
+	return n.entryAtLocked(index).Term
+}
+
+func (n *Node) indexOffsetLocked(index int) int {
+	return index - n.lastIncludedIndex - 1
+}
+
+func (n *Node) entryAtLocked(index int) LogEntry {
+	return n.log[n.indexOffsetLocked(index)]
+}
+
+func (n *Node) entriesFromLocked(index int) []LogEntry {
+	off := n.indexOffsetLocked(index)
+	if off < 0 || off >= len(n.log) {
+		return nil
+	}
+	return append([]LogEntry{}, n.log[off:]...)
+}
+
+// --- RPC wire types ---
+
+type RequestVoteArgs struct {
+	Term         int `json:"term"`
+	CandidateID  int `json:"candidateId"`
+	LastLogIndex int `json:"lastLogIndex"`
+	LastLogTerm  int `json:"lastLogTerm"`
+}
+
+type RequestVoteReply struct {
+	Term        int  `json:"term"`
+	VoteGranted bool `json:"voteGranted"`
+}
+
+type AppendEntriesArgs struct {
+	Term         int        `json:"term"`
+	LeaderID     int        `json:"leaderId"`
+	PrevLogIndex int        `json:"prevLogIndex"`
+	PrevLogTerm  int        `json:"prevLogTerm"`
+	Entries      []LogEntry `json:"entries"`
+	LeaderCommit int        `json:"leaderCommit"`
+}
+
+type AppendEntriesReply struct {
+	Term    int  `json:"term"`
+	Success bool `json:"success"`
+}
+
+type InstallSnapshotArgs struct {
+	Term     int      `json:"term"`
+	LeaderID int      `json:"leaderId"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type InstallSnapshotReply struct {
+	Term int `json:"term"`
+}
+
+// HandleRequestVote is called by the HTTP layer when a /internal/raft/requestvote
+// request arrives for this node.
+func (n *Node) HandleRequestVote(req RequestVoteArgs) RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.currentTerm {
+		n.stepDownLocked(req.Term)
+	}
+	if req.Term < n.currentTerm {
+		return RequestVoteReply{Term: n.currentTerm, VoteGranted: false}
+	}
+
+	lastIndex, lastTerm := n.lastLogIndexAndTermLocked()
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+	if (n.votedFor == -1 || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.persistLocked()
+		n.resetElectionTimer()
+		return RequestVoteReply{Term: n.currentTerm, VoteGranted: true}
+	}
+	return RequestVoteReply{Term: n.currentTerm, VoteGranted: false}
+}
+
+// HandleAppendEntries is called by the HTTP layer when a /internal/raft/appendentries
+// request arrives for this node.
+func (n *Node) HandleAppendEntries(req AppendEntriesArgs) AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm {
+		return AppendEntriesReply{Term: n.currentTerm, Success: false}
+	}
+	n.stepDownLocked(req.Term)
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	if req.PrevLogIndex > n.lastIndexLocked() || n.termAtLocked(req.PrevLogIndex) != req.PrevLogTerm {
+		n.persistLocked()
+		return AppendEntriesReply{Term: n.currentTerm, Success: false}
+	}
+
+	for _, entry := range req.Entries {
+		if entry.Index <= n.lastIncludedIndex {
+			continue
+		}
+		off := n.indexOffsetLocked(entry.Index)
+		switch {
+		case off < len(n.log) && n.log[off].Term != entry.Term:
+			n.log = n.log[:off]
+			n.log = append(n.log, entry)
+		case off == len(n.log):
+			n.log = append(n.log, entry)
+		}
+	}
+	n.persistLocked()
+
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = req.LeaderCommit
+		if last := n.lastIndexLocked(); n.commitIndex > last {
+			n.commitIndex = last
+		}
+		n.applyCommittedLocked()
+	}
+	return AppendEntriesReply{Term: n.currentTerm, Success: true}
+}
+
+// HandleInstallSnapshot is called by the HTTP layer when a
+// /internal/raft/installsnapshot request arrives for this node.
+func (n *Node) HandleInstallSnapshot(req InstallSnapshotArgs) InstallSnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm {
+		return InstallSnapshotReply{Term: n.currentTerm}
+	}
+	n.stepDownLocked(req.Term)
+	n.leaderID = req.LeaderID
+	n.resetElectionTimer()
+
+	if src, ok := n.applier.(SnapshotSource); ok {
+		src.Restore(req.Snapshot.State)
+	}
+	n.lastIncludedIndex = req.Snapshot.LastIncludedIndex
+	n.lastIncludedTerm = req.Snapshot.LastIncludedTerm
+	n.log = nil
+	n.commitIndex = req.Snapshot.LastIncludedIndex
+	n.lastApplied = req.Snapshot.LastIncludedIndex
+	n.persistLocked()
+	return InstallSnapshotReply{Term: n.currentTerm}
+}
+
+func (n *Node) call(ctx context.Context, addr, path string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+addr+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// --- plain net/http handlers; the HTTP layer wires these into its router
+// via gin.WrapF so this package stays free of a gin dependency ---
+
+// ServeRequestVote handles an incoming RequestVote RPC.
+func (n *Node) ServeRequestVote(w http.ResponseWriter, r *http.Request) {
+	var req RequestVoteArgs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.HandleRequestVote(req))
+}
+
+// ServeAppendEntries handles an incoming AppendEntries RPC.
+func (n *Node) ServeAppendEntries(w http.ResponseWriter, r *http.Request) {
+	var req AppendEntriesArgs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.HandleAppendEntries(req))
+}
+
+// ServeInstallSnapshot handles an incoming InstallSnapshot RPC.
+func (n *Node) ServeInstallSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req InstallSnapshotArgs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.HandleInstallSnapshot(req))
+}
@@ -0,0 +1,68 @@
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// PersistentState is the subset of Raft state that must survive a process
+// restart: currentTerm, votedFor, the log, and the snapshot metadata
+// needed to interpret log indices relative to it. Losing any of these on
+// restart risks violating Election Safety (granting a second, conflicting
+// vote in a term already voted in) or forgetting log entries this node
+// already acknowledged to a leader.
+type PersistentState struct {
+	CurrentTerm       int        `json:"currentTerm"`
+	VotedFor          int        `json:"votedFor"`
+	Log               []LogEntry `json:"log"`
+	LastIncludedIndex int        `json:"lastIncludedIndex"`
+	LastIncludedTerm  int        `json:"lastIncludedTerm"`
+}
+
+// StateStorage persists PersistentState across restarts. A Node with no
+// StateStorage configured (the default) keeps this state in memory only,
+// same as before this type existed.
+type StateStorage interface {
+	Save(state PersistentState) error
+	Load() (state PersistentState, found bool, err error)
+}
+
+// fileStateStorage persists PersistentState as a single JSON file, written
+// to a temp path and renamed into place so a crash mid-write never leaves
+// a corrupt file behind.
+type fileStateStorage struct {
+	path string
+}
+
+// NewFileStateStorage returns a StateStorage backed by a JSON file at path.
+func NewFileStateStorage(path string) StateStorage {
+	return &fileStateStorage{path: path}
+}
+
+func (f *fileStateStorage) Save(state PersistentState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *fileStateStorage) Load() (PersistentState, bool, error) {
+	raw, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return PersistentState{}, false, nil
+	}
+	if err != nil {
+		return PersistentState{}, false, err
+	}
+	var state PersistentState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return PersistentState{}, false, err
+	}
+	return state, true, nil
+}
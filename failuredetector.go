@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VedantUpadhyay/Primary-BackUp-FailOver/consensus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultHeartbeatInterval is how often each node pings its peers.
+	defaultHeartbeatInterval = 500 * time.Millisecond
+	// pingTimeout bounds a single heartbeat or gossip RPC.
+	pingTimeout = 300 * time.Millisecond
+	// suspicionThreshold is how many consecutive missed heartbeats a peer
+	// tolerates before being evicted from the view.
+	suspicionThreshold = 3
+)
+
+// peerHealth tracks liveness bookkeeping for one peer. Rather than
+// modeling a full phi-accrual inter-arrival distribution, it escalates a
+// suspicion counter on every missed heartbeat and resets it on every
+// successful one - enough to tell a transient blip from a genuinely dead
+// peer without the statistical machinery a full phi-accrual detector
+// needs.
+type peerHealth struct {
+	suspicion int
+}
+
+// FailureDetector periodically pings every peer in the cluster view and
+// evicts any that miss enough consecutive heartbeats. Evictions (and
+// /admin/addPeer, /admin/removePeer) update the local ClusterView, feed
+// the new peer set to the Raft node, and gossip the resulting view to the
+// remaining live peers so the cluster converges without an external
+// coordinator. Raft's own election timeout - not this detector - is what
+// promotes a new leader if the evicted peer was the primary; this
+// detector only owns membership, not leadership.
+type FailureDetector struct {
+	view     *ClusterView
+	node     *consensus.Node
+	interval time.Duration
+	client   *http.Client
+
+	mu     sync.Mutex
+	health map[int]*peerHealth
+}
+
+// NewFailureDetector constructs a FailureDetector that pings peers of view
+// every interval.
+func NewFailureDetector(view *ClusterView, node *consensus.Node, interval time.Duration) *FailureDetector {
+	return &FailureDetector{
+		view:     view,
+		node:     node,
+		interval: interval,
+		client:   &http.Client{Timeout: pingTimeout},
+		health:   make(map[int]*peerHealth),
+	}
+}
+
+// Run starts the heartbeat loop. It blocks until ctx is cancelled.
+func (f *FailureDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.tick(ctx)
+		}
+	}
+}
+
+func (f *FailureDetector) tick(ctx context.Context) {
+	peers := f.view.Peers()
+	var mu sync.Mutex
+	var dead []int
+	var wg sync.WaitGroup
+	for id, addr := range peers {
+		wg.Add(1)
+		go func(id int, addr string) {
+			defer wg.Done()
+			alive := f.ping(ctx, addr)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if alive {
+				f.view.RecordSeen(id, time.Now())
+				f.mu.Lock()
+				delete(f.health, id)
+				f.mu.Unlock()
+				return
+			}
+			f.mu.Lock()
+			h, ok := f.health[id]
+			if !ok {
+				h = &peerHealth{}
+				f.health[id] = h
+			}
+			h.suspicion++
+			suspicious := h.suspicion >= suspicionThreshold
+			f.mu.Unlock()
+			if suspicious {
+				dead = append(dead, id)
+			}
+		}(id, addr)
+	}
+	wg.Wait()
+
+	for _, id := range dead {
+		logrus.WithField("peer", id).Warn("peer missed too many heartbeats, evicting from view")
+		f.RemovePeer(ctx, id)
+	}
+}
+
+// ping sends a single GET /ping to addr, returning whether it succeeded.
+func (f *FailureDetector) ping(ctx context.Context, addr string) bool {
+	rctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet, "http://"+addr+"/ping", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// AddPeer adds id/addr to the view, updates the Raft node's peer set, and
+// gossips the change to the rest of the cluster.
+func (f *FailureDetector) AddPeer(ctx context.Context, id int, addr string) {
+	f.view.AddPeer(id, addr)
+	f.node.SetPeers(f.view.Peers())
+	f.gossip(ctx, f.view.Snapshot())
+}
+
+// RemovePeer removes id from the view, updates the Raft node's peer set,
+// and gossips the change to the rest of the cluster.
+func (f *FailureDetector) RemovePeer(ctx context.Context, id int) {
+	f.view.RemovePeer(id)
+	f.mu.Lock()
+	delete(f.health, id)
+	f.mu.Unlock()
+	f.node.SetPeers(f.view.Peers())
+	f.gossip(ctx, f.view.Snapshot())
+}
+
+// gossip pushes view to every remaining peer via a normal /view PUT, so
+// views converge across the cluster without needing an external
+// coordinator to push a consistent membership list to everyone at once.
+func (f *FailureDetector) gossip(ctx context.Context, view map[int]string) {
+	payload, err := encodeViewPayload(view)
+	if err != nil {
+		logrus.WithError(err).Error("failed to encode view for gossip")
+		return
+	}
+	for id, addr := range f.view.Peers() {
+		go func(id int, addr string) {
+			rctx, cancel := context.WithTimeout(ctx, pingTimeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(rctx, http.MethodPut, "http://"+addr+"/view", bytes.NewReader(payload))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := f.client.Do(req)
+			if err != nil {
+				logrus.WithError(err).WithField("peer", id).Warn("failed to gossip view update")
+				return
+			}
+			resp.Body.Close()
+		}(id, addr)
+	}
+}
+
+// encodeViewPayload marshals view into the same JSON shape ViewRequest
+// expects, so it can be PUT straight to a peer's /view endpoint.
+func encodeViewPayload(view map[int]string) ([]byte, error) {
+	type viewItem struct {
+		ID      int    `json:"id"`
+		Address string `json:"address"`
+	}
+	type viewRequest struct {
+		View []viewItem `json:"view"`
+	}
+	items := make([]viewItem, 0, len(view))
+	for id, addr := range view {
+		items = append(items, viewItem{ID: id, Address: addr})
+	}
+	return json.Marshal(viewRequest{View: items})
+}
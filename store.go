@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/VedantUpadhyay/Primary-BackUp-FailOver/consensus"
+)
+
+// ErrConflict is returned when a conditional write's expected resource
+// version doesn't match the key's current one.
+var ErrConflict = errors.New("resource version conflict")
+
+// ResourceValue is a value paired with the resource version it was
+// written at, as returned from GetAll/Iterate and sent over the wire.
+type ResourceValue struct {
+	Value           string `json:"value"`
+	ResourceVersion uint64 `json:"resourceVersion"`
+}
+
+// KVStore is the storage backend behind the HTTP surface. MemStore is the
+// original in-memory implementation; DiskStore persists to a BoltDB file
+// so a restarted node rejoins the cluster with its data intact. Both
+// implement consensus.Applier and consensus.SnapshotSource so either can
+// sit behind a Raft node.
+type KVStore interface {
+	Get(key string) (val string, rv uint64, ok bool)
+	Set(key, val string) (existed bool, rv uint64)
+	Delete(key string) bool
+	GetAll() map[string]ResourceValue
+	Iterate(prefix string) map[string]ResourceValue
+	CompareAndSwap(key string, expectedRV uint64, newVal string) (uint64, error)
+	CompareAndDelete(key string, expectedRV uint64) error
+	GuaranteedUpdate(ctx context.Context, key string, tryUpdate func(cur string, rv uint64) (string, error)) error
+	Watch(ctx context.Context, keyPrefix string, sinceRV uint64) (<-chan Event, CancelFunc)
+
+	consensus.Applier
+	consensus.SnapshotSource
+}
+
+// newStoreFromEnv selects a storage backend via STORAGE_BACKEND
+// (mem|bolt, default mem).
+func newStoreFromEnv() (KVStore, error) {
+	switch backend := getenvDefault("STORAGE_BACKEND", "mem"); backend {
+	case "mem":
+		return NewMemStore(), nil
+	case "bolt":
+		path := getenvDefault("STORAGE_PATH", "data.db")
+		return NewDiskStore(path)
+	default:
+		return nil, errors.New("unknown STORAGE_BACKEND " + backend)
+	}
+}
+
+func getenvDefault(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/VedantUpadhyay/Primary-BackUp-FailOver/consensus"
+)
+
+// versionedValue is a stored value together with the resource version it
+// was last written at.
+type versionedValue struct {
+	value string
+	rv    uint64
+}
+
+// MemStore is a thread-safe in-memory KVStore. Every Set/Delete bumps a
+// store-wide monotonically increasing ResourceVersion, mirroring etcd3's
+// mod-revision, so callers can do optimistic-concurrency writes. It does
+// not survive a restart; use DiskStore for that.
+type MemStore struct {
+	mu     sync.RWMutex
+	store  map[string]versionedValue
+	nextRV uint64
+	hub    *watchHub
+}
+
+// NewMemStore initializes an in-memory KVStore.
+func NewMemStore() *MemStore {
+	return &MemStore{store: make(map[string]versionedValue), hub: newWatchHub()}
+}
+
+// Watch subscribes to changes to keys under keyPrefix, see watchHub.Watch.
+func (s *MemStore) Watch(ctx context.Context, keyPrefix string, sinceRV uint64) (<-chan Event, CancelFunc) {
+	return s.hub.Watch(ctx, keyPrefix, sinceRV)
+}
+
+// Get retrieves a value and its resource version, returns ok=true if found
+func (s *MemStore) Get(key string) (val string, rv uint64, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.store[key]
+	return v.value, v.rv, ok
+}
+
+// Set unconditionally stores a value, bumping its resource version, and
+// returns (existed, newResourceVersion).
+func (s *MemStore) Set(key, val string) (existed bool, rv uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, existed = s.store[key]
+	rv = s.nextRVLocked()
+	s.store[key] = versionedValue{value: val, rv: rv}
+	s.hub.publish(Event{Type: EventPut, Key: key, Value: val, ResourceVersion: rv})
+	return existed, rv
+}
+
+// Delete removes a key, returns true if key existed
+func (s *MemStore) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, existed := s.store[key]
+	if existed {
+		delete(s.store, key)
+		s.hub.publish(Event{Type: EventDelete, Key: key, ResourceVersion: s.nextRVLocked()})
+	}
+	return existed
+}
+
+// CompareAndSwap writes newVal only if the key's current resource version
+// equals expectedRV (expectedRV == 0 means "key must not exist yet"). On
+// success it returns the freshly assigned resource version; otherwise it
+// returns ErrConflict without modifying the store.
+func (s *MemStore) CompareAndSwap(key string, expectedRV uint64, newVal string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.store[key]
+	if (ok && cur.rv != expectedRV) || (!ok && expectedRV != 0) {
+		return 0, ErrConflict
+	}
+	rv := s.nextRVLocked()
+	s.store[key] = versionedValue{value: newVal, rv: rv}
+	s.hub.publish(Event{Type: EventPut, Key: key, Value: newVal, ResourceVersion: rv})
+	return rv, nil
+}
+
+// CompareAndDelete removes key only if its current resource version
+// equals expectedRV, otherwise it returns ErrConflict.
+func (s *MemStore) CompareAndDelete(key string, expectedRV uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.store[key]
+	if !ok || cur.rv != expectedRV {
+		return ErrConflict
+	}
+	delete(s.store, key)
+	s.hub.publish(Event{Type: EventDelete, Key: key, ResourceVersion: s.nextRVLocked()})
+	return nil
+}
+
+// GuaranteedUpdate loops tryUpdate against the current value until it can
+// be committed without a conflicting concurrent write, re-fetching and
+// re-invoking the mutator whenever another writer wins the race -
+// mirroring etcd3's storage.GuaranteedUpdate pattern.
+func (s *MemStore) GuaranteedUpdate(ctx context.Context, key string, tryUpdate func(cur string, rv uint64) (string, error)) error {
+	for {
+		cur, rv, _ := s.Get(key)
+		newVal, err := tryUpdate(cur, rv)
+		if err != nil {
+			return err
+		}
+		if _, err := s.CompareAndSwap(key, rv, newVal); err != nil {
+			if errors.Is(err, ErrConflict) {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+					continue
+				}
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// nextRVLocked returns the next resource version. Callers must hold s.mu.
+func (s *MemStore) nextRVLocked() uint64 {
+	s.nextRV++
+	return s.nextRV
+}
+
+// GetAll returns a copy of the entire store.
+func (s *MemStore) GetAll() map[string]ResourceValue {
+	return s.Iterate("")
+}
+
+// Iterate returns a copy of every key with the given prefix (all keys if
+// prefix is empty).
+func (s *MemStore) Iterate(prefix string) map[string]ResourceValue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp := make(map[string]ResourceValue)
+	for k, v := range s.store {
+		if strings.HasPrefix(k, prefix) {
+			cp[k] = ResourceValue{Value: v.value, ResourceVersion: v.rv}
+		}
+	}
+	return cp
+}
+
+// Apply applies a committed Raft log entry to the store, implementing
+// consensus.Applier. It assigns the entry's resource version itself,
+// rather than trusting one stamped at proposal time, so every replica
+// assigns the same version to the same entry by construction: Apply only
+// ever runs on the single-threaded apply path, in commit order, from the
+// same starting nextRV on every replica. If the command carries an
+// expected resource version, the check and the write happen under the
+// same lock acquisition, so two conditional writes committed back-to-back
+// can never both succeed against the same expected version.
+func (s *MemStore) Apply(cmd consensus.Command) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cmd.HasExpectedResourceVersion {
+		cur, ok := s.store[cmd.Key]
+		if (ok && cur.rv != cmd.ExpectedResourceVersion) || (!ok && cmd.ExpectedResourceVersion != 0) {
+			return 0, ErrConflict
+		}
+	}
+	rv := s.nextRVLocked()
+	switch cmd.Op {
+	case "set":
+		s.store[cmd.Key] = versionedValue{value: cmd.Value, rv: rv}
+		s.hub.publish(Event{Type: EventPut, Key: cmd.Key, Value: cmd.Value, ResourceVersion: rv})
+	case "delete":
+		delete(s.store, cmd.Key)
+		s.hub.publish(Event{Type: EventDelete, Key: cmd.Key, ResourceVersion: rv})
+	}
+	return rv, nil
+}
+
+// Snapshot returns a copy of the whole store, implementing
+// consensus.SnapshotSource so a lagging follower can be caught up without
+// replaying its entire log. Each entry carries its resource version so the
+// follower converges on the same versions as a node that replayed the log.
+func (s *MemStore) Snapshot() map[string]consensus.SnapshotEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp := make(map[string]consensus.SnapshotEntry, len(s.store))
+	for k, v := range s.store {
+		cp[k] = consensus.SnapshotEntry{Value: v.value, ResourceVersion: v.rv}
+	}
+	return cp
+}
+
+// Restore replaces the store's contents with state, implementing
+// consensus.SnapshotSource, and fast-forwards nextRV past the highest
+// restored version so subsequently assigned versions never collide with
+// ones carried in the snapshot.
+func (s *MemStore) Restore(state map[string]consensus.SnapshotEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = make(map[string]versionedValue, len(state))
+	for k, v := range state {
+		s.store[k] = versionedValue{value: v.Value, rv: v.ResourceVersion}
+		if v.ResourceVersion > s.nextRV {
+			s.nextRV = v.ResourceVersion
+		}
+	}
+}
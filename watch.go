@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// maxEventHistory bounds the in-memory ring buffer of recent events kept
+// for watchers resuming with a sinceRV.
+const maxEventHistory = 1000
+
+// EventType identifies the kind of change a watch Event describes.
+type EventType string
+
+const (
+	EventPut    EventType = "PUT"
+	EventDelete EventType = "DELETE"
+	// EventGone is emitted when a watcher resumes from a sinceRV that has
+	// already been evicted from the history buffer, so it knows it may
+	// have missed changes.
+	EventGone EventType = "GONE"
+)
+
+// Event describes a single change to the store, or the fact that a
+// watcher's resume point is too old to catch up from.
+type Event struct {
+	Type            EventType `json:"type"`
+	Key             string    `json:"key"`
+	Value           string    `json:"value,omitempty"`
+	ResourceVersion uint64    `json:"resourceVersion"`
+}
+
+// CancelFunc stops a watch and releases its resources.
+type CancelFunc func()
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+// watchHub fans committed events out to registered watchers and keeps a
+// bounded history so a reconnecting client can resume from a sinceRV
+// without missing anything still in the buffer.
+type watchHub struct {
+	mu       sync.Mutex
+	history  []Event
+	watchers map[int]*watcher
+	nextID   int
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[int]*watcher)}
+}
+
+// publish records ev in the history buffer and delivers it to every
+// watcher whose prefix matches. Slow watchers that can't keep up have the
+// event dropped rather than blocking the writer.
+func (h *watchHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = append(h.history, ev)
+	if len(h.history) > maxEventHistory {
+		h.history = h.history[len(h.history)-maxEventHistory:]
+	}
+	for _, w := range h.watchers {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+		}
+	}
+}
+
+// Watch subscribes to changes under keyPrefix, replaying any buffered
+// events after sinceRV before delivering new ones live. If sinceRV has
+// already fallen out of the history buffer, the first event delivered is
+// an EventGone so the caller knows it may have missed updates. Passing
+// sinceRV == 0 subscribes to live events only.
+func (h *watchHub) Watch(ctx context.Context, keyPrefix string, sinceRV uint64) (<-chan Event, CancelFunc) {
+	ch := make(chan Event, 64)
+
+	h.mu.Lock()
+	id := h.nextID
+	h.nextID++
+	h.watchers[id] = &watcher{prefix: keyPrefix, ch: ch}
+
+	var backlog []Event
+	if sinceRV > 0 {
+		if len(h.history) > 0 && h.history[0].ResourceVersion > sinceRV+1 {
+			backlog = append(backlog, Event{Type: EventGone, ResourceVersion: sinceRV})
+		}
+		for _, ev := range h.history {
+			if ev.ResourceVersion > sinceRV && strings.HasPrefix(ev.Key, keyPrefix) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	go func() {
+		for _, ev := range backlog {
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	cancel := func() {
+		h.mu.Lock()
+		delete(h.watchers, id)
+		h.mu.Unlock()
+	}
+	return ch, cancel
+}
@@ -1,408 +1,567 @@
-package main
-
-import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"math"
-	"net"
-	"net/http"
-	"os"
-	"strconv"
-	"sync"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
-)
-
-const defaultTimeout = 30 * time.Second
-
-// abortWithError sends a JSON error and aborts the request
-func abortWithError(c *gin.Context, code int, err error) {
-	logrus.WithError(err).WithField("status", code).Error("request failed")
-	c.JSON(code, gin.H{
-		"statusCode": strconv.Itoa(code),
-		"message":    err.Error(),
-	})
-	c.Abort()
-}
-
-// ClusterView manages cluster topology and primary selection
-type ClusterView struct {
-	mu        sync.RWMutex
-	nodes     map[int]string
-	primaryID int
-	selfID    int
-	timeout   time.Duration
-}
-
-// NewClusterView initializes the view for this node
-func NewClusterView(selfID int, timeout time.Duration) *ClusterView {
-	return &ClusterView{
-		nodes:     make(map[int]string),
-		primaryID: -1,
-		selfID:    selfID,
-		timeout:   timeout,
-	}
-}
-
-// Update replaces the entire view and recalculates the primary
-func (v *ClusterView) Update(view map[int]string) {
-	v.mu.Lock()
-	defer v.mu.Unlock()
-	v.nodes = view
-	minID := math.MaxInt
-	for id := range view {
-		if id < minID {
-			minID = id
-		}
-	}
-	v.primaryID = minID
-}
-
-// IsOnline returns true if we have a view
-type ViewRequest struct {
-	View []struct {
-		ID      int    `json:"id" binding:"required"`
-		Address string `json:"address" binding:"required"`
-	} `json:"view" binding:"required"`
-}
-
-func (v *ClusterView) IsOnline() bool {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	return len(v.nodes) > 0
-}
-
-// IsPrimary returns true if this node is the primary
-func (v *ClusterView) IsPrimary() bool {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	return v.selfID == v.primaryID
-}
-
-// PrimaryAddr returns the address of the primary node
-func (v *ClusterView) PrimaryAddr() string {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	return v.nodes[v.primaryID]
-}
-
-// Backups returns the addresses of all non-primary nodes
-func (v *ClusterView) Backups() []string {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	addrs := make([]string, 0, len(v.nodes)-1)
-	for id, addr := range v.nodes {
-		if id != v.primaryID {
-			addrs = append(addrs, addr)
-		}
-	}
-	return addrs
-}
-
-// KVStore is a thread-safe in-memory key-value store
-type KVStore struct {
-	mu    sync.RWMutex
-	store map[string]string
-}
-
-// NewKVStore initializes the key-value store
-func NewKVStore() *KVStore {
-	return &KVStore{store: make(map[string]string)}
-}
-
-// Get retrieves a value, returns (value, true) if found
-func (s *KVStore) Get(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	val, ok := s.store[key]
-	return val, ok
-}
-
-// Set stores a value, returns true if key existed
-func (s *KVStore) Set(key, val string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, existed := s.store[key]
-	s.store[key] = val
-	return existed
-}
-
-// Delete removes a key, returns true if key existed
-func (s *KVStore) Delete(key string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, existed := s.store[key]
-	if existed {
-		delete(s.store, key)
-	}
-	return existed
-}
-
-// GetAll returns a copy of the entire store
-func (s *KVStore) GetAll() map[string]string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	cp := make(map[string]string, len(s.store))
-	for k, v := range s.store {
-		cp[k] = v
-	}
-	return cp
-}
-
-// Handler ties HTTP routes to store and view logic
-type Handler struct {
-	view  *ClusterView
-	store *KVStore
-}
-
-// NewHandler constructs a Handler
-func NewHandler(view *ClusterView, store *KVStore) *Handler {
-	return &Handler{view: view, store: store}
-}
-
-// proxy forwards client requests to the primary node
-func (h *Handler) proxy(c *gin.Context, method, path string, body io.Reader) {
-	ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, method, "http://"+h.view.PrimaryAddr()+path, body)
-	if err != nil {
-		abortWithError(c, http.StatusInternalServerError, err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		if ne, ok := err.(net.Error); ok && ne.Timeout() {
-			abortWithError(c, http.StatusRequestTimeout, fmt.Errorf("proxy timed out: %w", err))
-		} else {
-			abortWithError(c, http.StatusBadGateway, err)
-		}
-		return
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(resp.Body)
-	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
-}
-
-func (h *Handler) fanOut(ctx context.Context, op func(string) error) error {
-	var wg sync.WaitGroup
-	errCh := make(chan error, len(h.view.Backups()))
-
-	for _, addr := range h.view.Backups() {
-		wg.Add(1)
-		go func(a string) {
-			defer wg.Done()
-			if err := op(a); err != nil {
-				errCh <- err
-			}
-		}(addr)
-	}
-
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-done:
-		select {
-		case err := <-errCh:
-			return err
-		default:
-			return nil
-		}
-	}
-}
-
-// sendBackupPut issues a PUT to /internal/data on a backup
-func (h *Handler) sendBackupPut(ctx context.Context, key, val, addr string) error {
-	payload, _ := json.Marshal(map[string]string{"value": val})
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, "http://"+addr+"/internal/data/"+key, bytes.NewReader(payload))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	var respMap map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&respMap); err != nil {
-		return err
-	}
-	if code, ok := respMap["statusCode"]; !ok || (code != "200" && code != "201") {
-		return fmt.Errorf("backup responded %v", respMap)
-	}
-	return nil
-}
-
-// sendBackupDelete issues a DELETE to /internal/data on a backup
-func (h *Handler) sendBackupDelete(ctx context.Context, key, addr string) error {
-	req, _ := http.NewRequestWithContext(ctx, http.MethodDelete, "http://"+addr+"/internal/data/"+key, nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	var respMap map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&respMap); err != nil {
-		return err
-	}
-	if code, ok := respMap["statusCode"]; !ok || code != "200" {
-		return fmt.Errorf("backup responded %v", respMap)
-	}
-	return nil
-}
-
-// Handlers for view & data operations
-func (h *Handler) Ping(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"statusCode": "200", "message": "ready to handle requests"})
-}
-
-func (h *Handler) TestView(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"primary": h.view.PrimaryAddr(),
-		"id":      h.view.selfID,
-		"view":    h.view.nodes,
-	})
-}
-
-func (h *Handler) UpdateView(c *gin.Context) {
-	var req ViewRequest
-	if err := c.BindJSON(&req); err != nil {
-		abortWithError(c, http.StatusBadRequest, err)
-		return
-	}
-	m := make(map[int]string, len(req.View))
-	for _, item := range req.View {
-		m[item.ID] = item.Address
-	}
-	h.view.Update(m)
-	c.JSON(http.StatusOK, gin.H{"message": "view updated"})
-}
-
-func (h *Handler) GetResource(c *gin.Context) {
-	key := c.Param("key")
-	if !h.view.IsOnline() {
-		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
-		return
-	}
-	if !h.view.IsPrimary() {
-		h.proxy(c, http.MethodGet, "/data/"+key, nil)
-		return
-	}
-	if val, ok := h.store.Get(key); ok {
-		c.JSON(http.StatusOK, gin.H{"statusCode": "200", "value": val})
-	} else {
-		abortWithError(c, http.StatusNotFound, fmt.Errorf("key doesn't exist"))
-	}
-}
-
-func (h *Handler) GetAllResources(c *gin.Context) {
-	if !h.view.IsOnline() {
-		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
-		return
-	}
-	if !h.view.IsPrimary() {
-		h.proxy(c, http.MethodGet, "/data", nil)
-		return
-	}
-	c.JSON(http.StatusOK, h.store.GetAll())
-}
-
-func (h *Handler) UpdateResource(c *gin.Context) {
-	if !h.view.IsOnline() {
-		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
-		return
-	}
-	key := c.Param("key")
-	var body map[string]string
-	if err := c.BindJSON(&body); err != nil {
-		abortWithError(c, http.StatusBadRequest, err)
-		return
-	}
-	val, ok := body["value"]
-	if !ok {
-		abortWithError(c, http.StatusBadRequest, fmt.Errorf("missing value field"))
-		return
-	}
-	if h.view.IsPrimary() {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
-		defer cancel()
-		if err := h.fanOut(ctx, func(addr string) error {
-			return h.sendBackupPut(ctx, key, val, addr)
-		}); err != nil {
-			abortWithError(c, http.StatusGatewayTimeout, err)
-			return
-		}
-		existed := h.store.Set(key, val)
-		code := http.StatusCreated
-		if existed {
-			code = http.StatusOK
-		}
-		c.JSON(code, gin.H{"statusCode": strconv.Itoa(code)})
-	} else {
-		// proxy to primary
-		payload, _ := json.Marshal(body)
-		h.proxy(c, http.MethodPut, "/data/"+key, bytes.NewReader(payload))
-	}
-}
-
-func (h *Handler) DeleteResource(c *gin.Context) {
-	if !h.view.IsOnline() {
-		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
-		return
-	}
-	key := c.Param("key")
-	if !h.store.Delete(key) {
-		abortWithError(c, http.StatusNotFound, fmt.Errorf("key doesn't exist"))
-		return
-	}
-	if h.view.IsPrimary() {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
-		defer cancel()
-		if err := h.fanOut(ctx, func(addr string) error {
-			return h.sendBackupDelete(ctx, key, addr)
-		}); err != nil {
-			abortWithError(c, http.StatusGatewayTimeout, err)
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"statusCode": "200"})
-	} else {
-		h.proxy(c, http.MethodDelete, "/data/"+key, nil)
-	}
-}
-
-func main() {
-	id, err := strconv.Atoi(os.Getenv("NODE_IDENTIFIER"))
-	if err != nil {
-		logrus.Fatal("invalid NODE_IDENTIFIER")
-	}
-	view := NewClusterView(id, defaultTimeout)
-	store := NewKVStore()
-	h := NewHandler(view, store)
-
-	r := gin.Default()
-	r.GET("/ping", h.Ping)
-	r.GET("/testview", h.TestView)
-	r.PUT("/view", h.UpdateView)
-
-	r.GET("/data/:key", h.GetResource)
-	r.GET("/data", h.GetAllResources)
-	r.PUT("/data/:key", h.UpdateResource)
-	r.DELETE("/data/:key", h.DeleteResource)
-
-	// internal
-	r.PUT("/internal/data/:key", h.UpdateResource)
-	r.DELETE("/internal/data/:key", h.DeleteResource)
-
-	r.Run(":8081")
-}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VedantUpadhyay/Primary-BackUp-FailOver/consensus"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// abortWithError sends a JSON error and aborts the request
+func abortWithError(c *gin.Context, code int, err error) {
+	logrus.WithError(err).WithField("status", code).Error("request failed")
+	c.JSON(code, gin.H{
+		"statusCode": strconv.Itoa(code),
+		"message":    err.Error(),
+	})
+	c.Abort()
+}
+
+// ClusterView tracks cluster membership addresses; leadership itself is
+// now decided by the consensus package rather than a min-ID rule.
+type ClusterView struct {
+	mu       sync.RWMutex
+	nodes    map[int]string
+	lastSeen map[int]time.Time
+	selfID   int
+	timeout  time.Duration
+	version  int
+}
+
+// NewClusterView initializes the view for this node
+func NewClusterView(selfID int, timeout time.Duration) *ClusterView {
+	return &ClusterView{
+		nodes:    make(map[int]string),
+		lastSeen: make(map[int]time.Time),
+		selfID:   selfID,
+		timeout:  timeout,
+	}
+}
+
+// Update replaces the entire view of node addresses. Existing LastSeen
+// timestamps are kept for nodes that survive the update.
+func (v *ClusterView) Update(view map[int]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.nodes = view
+	for id := range v.lastSeen {
+		if _, ok := view[id]; !ok {
+			delete(v.lastSeen, id)
+		}
+	}
+	v.version++
+}
+
+// AddPeer adds or updates a single node in the view, for the
+// /admin/addPeer endpoint.
+func (v *ClusterView) AddPeer(id int, addr string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.nodes[id] = addr
+	v.version++
+}
+
+// RemovePeer removes a single node from the view, for the
+// /admin/removePeer endpoint and the failure detector.
+func (v *ClusterView) RemovePeer(id int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.nodes, id)
+	delete(v.lastSeen, id)
+	v.version++
+}
+
+// RecordSeen timestamps a successful heartbeat from id, so /testview can
+// show how recently we last heard from each peer.
+func (v *ClusterView) RecordSeen(id int, t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastSeen[id] = t
+}
+
+// Snapshot returns a copy of the current node-ID-to-address view.
+func (v *ClusterView) Snapshot() map[int]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	cp := make(map[int]string, len(v.nodes))
+	for id, addr := range v.nodes {
+		cp[id] = addr
+	}
+	return cp
+}
+
+// LastSeenSnapshot returns a copy of the last-successful-heartbeat
+// timestamp per peer ID.
+func (v *ClusterView) LastSeenSnapshot() map[int]time.Time {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	cp := make(map[int]time.Time, len(v.lastSeen))
+	for id, t := range v.lastSeen {
+		cp[id] = t
+	}
+	return cp
+}
+
+// Version returns how many times this view has been updated, for exposing
+// as a metric.
+func (v *ClusterView) Version() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.version
+}
+
+// Size returns the number of nodes in the current view, including self.
+func (v *ClusterView) Size() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.nodes)
+}
+
+// IsOnline returns true if we have a view
+type ViewRequest struct {
+	View []struct {
+		ID      int    `json:"id" binding:"required"`
+		Address string `json:"address" binding:"required"`
+	} `json:"view" binding:"required"`
+}
+
+func (v *ClusterView) IsOnline() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.nodes) > 0
+}
+
+// SelfAddr returns this node's own address, as given to it in the view.
+func (v *ClusterView) SelfAddr() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.nodes[v.selfID]
+}
+
+// Peers returns the addresses of all other nodes in the view, keyed by ID.
+func (v *ClusterView) Peers() map[int]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	peers := make(map[int]string, len(v.nodes))
+	for id, addr := range v.nodes {
+		if id != v.selfID {
+			peers[id] = addr
+		}
+	}
+	return peers
+}
+
+// Handler ties HTTP routes to store, view and consensus logic
+type Handler struct {
+	view  *ClusterView
+	store KVStore
+	node  *consensus.Node
+	fd    *FailureDetector
+}
+
+// NewHandler constructs a Handler
+func NewHandler(view *ClusterView, store KVStore, node *consensus.Node, fd *FailureDetector) *Handler {
+	return &Handler{view: view, store: store, node: node, fd: fd}
+}
+
+// leaderAddr returns the address clients/followers should talk to for
+// writes: our own address if we're the Raft leader, otherwise the last
+// known leader hint.
+func (h *Handler) leaderAddr() (string, bool) {
+	if h.node.IsLeader() {
+		return h.view.SelfAddr(), true
+	}
+	return h.node.LeaderHint()
+}
+
+// redirectToLeader tells the client (or a stale follower) where the
+// current leader is instead of proxying the request ourselves.
+func (h *Handler) redirectToLeader(c *gin.Context, addr string) {
+	location := "http://" + addr + c.Request.URL.RequestURI()
+	c.Header("Location", location)
+	c.JSON(http.StatusTemporaryRedirect, gin.H{
+		"statusCode": strconv.Itoa(http.StatusTemporaryRedirect),
+		"message":    "not leader",
+		"leader":     addr,
+	})
+	c.Abort()
+}
+
+// proxy forwards client requests to the given node address
+func (h *Handler) proxy(c *gin.Context, method, addr, path string, body io.Reader) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+path, body)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			abortWithError(c, http.StatusRequestTimeout, fmt.Errorf("proxy timed out: %w", err))
+		} else {
+			abortWithError(c, http.StatusBadGateway, err)
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
+}
+
+// Handlers for view & data operations
+func (h *Handler) Ping(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"statusCode": "200", "message": "ready to handle requests"})
+}
+
+func (h *Handler) TestView(c *gin.Context) {
+	leader, _ := h.leaderAddr()
+	c.JSON(http.StatusOK, gin.H{
+		"primary":  leader,
+		"id":       h.view.selfID,
+		"view":     h.view.Snapshot(),
+		"lastSeen": h.view.LastSeenSnapshot(),
+	})
+}
+
+// AddPeerRequest is the body for POST /admin/addPeer.
+type AddPeerRequest struct {
+	ID      int    `json:"id" binding:"required"`
+	Address string `json:"address" binding:"required"`
+}
+
+// AddPeer adds a single node to the view without requiring a full /view
+// PUT, and gossips the change to the rest of the cluster.
+func (h *Handler) AddPeer(c *gin.Context) {
+	var req AddPeerRequest
+	if err := c.BindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	// The request's context is cancelled once this handler returns, which
+	// would abort the gossip still in flight.
+	h.fd.AddPeer(context.Background(), req.ID, req.Address)
+	c.JSON(http.StatusOK, gin.H{"message": "peer added"})
+}
+
+// RemovePeerRequest is the body for POST /admin/removePeer.
+type RemovePeerRequest struct {
+	ID int `json:"id" binding:"required"`
+}
+
+// RemovePeer removes a single node from the view without requiring a full
+// /view PUT, and gossips the change to the rest of the cluster.
+func (h *Handler) RemovePeer(c *gin.Context) {
+	var req RemovePeerRequest
+	if err := c.BindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	h.fd.RemovePeer(context.Background(), req.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "peer removed"})
+}
+
+func (h *Handler) UpdateView(c *gin.Context) {
+	var req ViewRequest
+	if err := c.BindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	m := make(map[int]string, len(req.View))
+	for _, item := range req.View {
+		m[item.ID] = item.Address
+	}
+	h.view.Update(m)
+	h.node.SetPeers(h.view.Peers())
+	c.JSON(http.StatusOK, gin.H{"message": "view updated"})
+}
+
+func (h *Handler) GetResource(c *gin.Context) {
+	key := c.Param("key")
+	if !h.view.IsOnline() {
+		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
+		return
+	}
+	if !h.node.IsLeader() {
+		leader, ok := h.leaderAddr()
+		if !ok {
+			abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("no known leader"))
+			return
+		}
+		h.proxy(c, http.MethodGet, leader, "/data/"+key, nil)
+		return
+	}
+	if val, rv, ok := h.store.Get(key); ok {
+		c.JSON(http.StatusOK, gin.H{"statusCode": "200", "value": val, "resourceVersion": rv})
+	} else {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("key doesn't exist"))
+	}
+}
+
+func (h *Handler) GetAllResources(c *gin.Context) {
+	if !h.view.IsOnline() {
+		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
+		return
+	}
+	if !h.node.IsLeader() {
+		leader, ok := h.leaderAddr()
+		if !ok {
+			abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("no known leader"))
+			return
+		}
+		h.proxy(c, http.MethodGet, leader, "/data", nil)
+		return
+	}
+	c.JSON(http.StatusOK, h.store.GetAll())
+}
+
+// WatchResource streams change events for a single key as NDJSON.
+func (h *Handler) WatchResource(c *gin.Context) {
+	h.watch(c, c.Param("key"))
+}
+
+// WatchAll streams change events for the whole store as NDJSON.
+func (h *Handler) WatchAll(c *gin.Context) {
+	h.watch(c, "")
+}
+
+// watch streams Events for keys under prefix to the client until it
+// disconnects. Every node applies committed writes identically (Raft
+// commits are applied on leader and followers alike), so watches are
+// served locally rather than redirected or proxied to the leader.
+func (h *Handler) watch(c *gin.Context, prefix string) {
+	if !h.view.IsOnline() {
+		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
+		return
+	}
+	var sinceRV uint64
+	if raw := c.Query("sinceResourceVersion"); raw != "" {
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, fmt.Errorf("invalid sinceResourceVersion %q: %w", raw, err))
+			return
+		}
+		sinceRV = v
+	}
+
+	ctx := c.Request.Context()
+	events, cancel := h.store.Watch(ctx, prefix, sinceRV)
+	defer cancel()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	flusher, canFlush := c.Writer.(http.Flusher)
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			line, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			if _, err := c.Writer.Write(append(line, '\n')); err != nil {
+				return
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseIfMatch reads the expected resource version for a conditional
+// write from the If-Match header, falling back to a resourceVersion field
+// in the body. ok is false if neither was supplied, meaning the write is
+// unconditional.
+func parseIfMatch(c *gin.Context, body map[string]string) (rv uint64, ok bool, err error) {
+	raw := c.GetHeader("If-Match")
+	if raw == "" {
+		raw, ok = body["resourceVersion"]
+		if !ok {
+			return 0, false, nil
+		}
+	}
+	rv, err = strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid resource version %q: %w", raw, err)
+	}
+	return rv, true, nil
+}
+
+func (h *Handler) UpdateResource(c *gin.Context) {
+	if !h.view.IsOnline() {
+		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
+		return
+	}
+	key := c.Param("key")
+	var body map[string]string
+	if err := c.BindJSON(&body); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	val, ok := body["value"]
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("missing value field"))
+		return
+	}
+	expectedRV, hasIfMatch, err := parseIfMatch(c, body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !h.node.IsLeader() {
+		leader, ok := h.leaderAddr()
+		if !ok {
+			abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("no known leader"))
+			return
+		}
+		h.redirectToLeader(c, leader)
+		return
+	}
+
+	// existed is only used to pick the response code (200 vs 201); the
+	// actual conditional-write check happens atomically on the apply path
+	// in Command.HasExpectedResourceVersion below, not here.
+	_, _, existed := h.store.Get(key)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
+	defer cancel()
+	cmd := consensus.Command{Op: "set", Key: key, Value: val}
+	if hasIfMatch {
+		cmd.HasExpectedResourceVersion = true
+		cmd.ExpectedResourceVersion = expectedRV
+	}
+	newRV, err := h.node.Propose(ctx, cmd)
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			abortWithError(c, http.StatusConflict, err)
+			return
+		}
+		abortWithError(c, http.StatusGatewayTimeout, err)
+		return
+	}
+	code := http.StatusCreated
+	if existed {
+		code = http.StatusOK
+	}
+	c.JSON(code, gin.H{"statusCode": strconv.Itoa(code), "resourceVersion": newRV})
+}
+
+func (h *Handler) DeleteResource(c *gin.Context) {
+	if !h.view.IsOnline() {
+		abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("waiting for view update"))
+		return
+	}
+	key := c.Param("key")
+	expectedRV, hasIfMatch, err := parseIfMatch(c, nil)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !h.node.IsLeader() {
+		leader, ok := h.leaderAddr()
+		if !ok {
+			abortWithError(c, http.StatusServiceUnavailable, fmt.Errorf("no known leader"))
+			return
+		}
+		h.redirectToLeader(c, leader)
+		return
+	}
+	// Existence is only a pre-check to return 404 without proposing
+	// anything; the actual conditional-delete check happens atomically on
+	// the apply path in Command.HasExpectedResourceVersion below.
+	if _, _, ok := h.store.Get(key); !ok {
+		abortWithError(c, http.StatusNotFound, fmt.Errorf("key doesn't exist"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.view.timeout)
+	defer cancel()
+	cmd := consensus.Command{Op: "delete", Key: key}
+	if hasIfMatch {
+		cmd.HasExpectedResourceVersion = true
+		cmd.ExpectedResourceVersion = expectedRV
+	}
+	if _, err := h.node.Propose(ctx, cmd); err != nil {
+		if errors.Is(err, ErrConflict) {
+			abortWithError(c, http.StatusConflict, err)
+			return
+		}
+		abortWithError(c, http.StatusGatewayTimeout, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statusCode": "200"})
+}
+
+func main() {
+	id, err := strconv.Atoi(os.Getenv("NODE_IDENTIFIER"))
+	if err != nil {
+		logrus.Fatal("invalid NODE_IDENTIFIER")
+	}
+	view := NewClusterView(id, defaultTimeout)
+	store, err := newStoreFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	node := consensus.NewNode(id, store)
+	node.SetMetrics(raftMetrics{})
+	if path := os.Getenv("RAFT_STATE_PATH"); path != "" {
+		if err := node.SetStorage(consensus.NewFileStateStorage(path)); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	fd := NewFailureDetector(view, node, defaultHeartbeatInterval)
+	h := NewHandler(view, store, node, fd)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node.Run(ctx)
+	go fd.Run(ctx)
+	go reportClusterMetrics(h, time.Second)
+
+	r := gin.Default()
+	r.Use(h.metricsMiddleware())
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/ping", h.Ping)
+	r.GET("/testview", h.TestView)
+	r.PUT("/view", h.UpdateView)
+	r.POST("/admin/addPeer", h.AddPeer)
+	r.POST("/admin/removePeer", h.RemovePeer)
+
+	r.GET("/data/:key", h.GetResource)
+	r.GET("/data", h.GetAllResources)
+	r.PUT("/data/:key", h.UpdateResource)
+	r.DELETE("/data/:key", h.DeleteResource)
+	r.GET("/data/:key/watch", h.WatchResource)
+	r.GET("/data/watch", h.WatchAll)
+
+	// internal: raft RPCs used for leader election and log replication
+	r.POST("/internal/raft/requestvote", gin.WrapF(node.ServeRequestVote))
+	r.POST("/internal/raft/appendentries", gin.WrapF(node.ServeAppendEntries))
+	r.POST("/internal/raft/installsnapshot", gin.WrapF(node.ServeInstallSnapshot))
+
+	r.Run(":8081")
+}